@@ -0,0 +1,60 @@
+// Command nbtee-cat decodes one or more streams written by
+// nbtee.FramedSink and prints each frame's header to stdout, one
+// line per frame: sequence number, timestamp, payload length, and
+// whether data was dropped upstream since the previous frame.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/tomclegg/nbtee"
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	for _, path := range args {
+		if err := cat(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func cat(path string) error {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	fr := nbtee.NewFramedReader(r)
+	for {
+		frame, err := fr.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dropped := ""
+		if frame.Dropped {
+			dropped = " dropped-since-last"
+		}
+		fmt.Printf("seq=%d ts=%s len=%d%s\n",
+			frame.Seq,
+			frame.Timestamp.Format(time.RFC3339Nano),
+			len(frame.Payload),
+			dropped)
+	}
+}
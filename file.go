@@ -0,0 +1,241 @@
+package nbtee
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy controls how often a FileSink calls fsync.
+type FsyncPolicy int
+
+const (
+	// FsyncNever never calls fsync; data durability is left to
+	// the OS's normal write-back behavior.
+	FsyncNever FsyncPolicy = iota
+	// FsyncOnRotate fsyncs the file (and, best-effort, its
+	// directory) only when rotating it out.
+	FsyncOnRotate
+	// FsyncEveryWrite fsyncs after every Write.
+	FsyncEveryWrite
+)
+
+// FileSinkOptions configures FileSink.
+type FileSinkOptions struct {
+	// MaxFileSize rotates the current file once it reaches this
+	// size. Zero means no size-based rotation.
+	MaxFileSize int64
+	// MaxAge rotates the current file once it has been open this
+	// long. Zero means no age-based rotation.
+	MaxAge time.Duration
+	// MaxTotalBytes deletes the oldest rotated files, after each
+	// rotation, until the total size of rotated files is at most
+	// this many bytes. Zero means rotated files are never deleted.
+	MaxTotalBytes int64
+	// Gzip compresses each file once it is rotated out.
+	Gzip bool
+	// Fsync selects how often the sink calls fsync.
+	Fsync FsyncPolicy
+	// Dropped, if non-nil, is called before each Write to get a
+	// cumulative count of bytes dropped upstream of this sink,
+	// typically SinkCloser.Stats().BytesDropped for a sink added
+	// with a non-blocking Policy. Whenever the count has grown
+	// since the last Write, FileSink records a one-line sentinel
+	// ("# nbtee: dropped N bytes\n") ahead of the new data, so
+	// anyone replaying the file can see where data is missing.
+	Dropped func() int64
+}
+
+type fileSink struct {
+	path string
+	opts FileSinkOptions
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+	lastDrop int64
+	closed   bool
+}
+
+// FileSink returns an io.WriteCloser that persists everything
+// written to it in path, rotating to a new file by size and/or age
+// and pruning old rotated files by total bytes on disk, as
+// configured by opts. Add its return value to a Writer like any
+// other sink.
+//
+// Rotation closes the current file, renames it (atomically, on the
+// same filesystem) to path plus a timestamp suffix, optionally gzips
+// it, and opens a fresh file at path.
+func FileSink(path string, opts FileSinkOptions) (io.WriteCloser, error) {
+	fs := &fileSink{path: path, opts: opts}
+	if err := fs.openCurrent(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *fileSink) openCurrent() error {
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.f = f
+	fs.size = info.Size()
+	fs.openedAt = time.Now()
+	return nil
+}
+
+func (fs *fileSink) Write(buf []byte) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return 0, os.ErrClosed
+	}
+	if err := fs.rotateIfDueLocked(); err != nil {
+		return 0, err
+	}
+	if fs.opts.Dropped != nil {
+		if dropped := fs.opts.Dropped(); dropped > fs.lastDrop {
+			sentinel := []byte(fmt.Sprintf("# nbtee: dropped %d bytes\n", dropped-fs.lastDrop))
+			if err := fs.writeLocked(sentinel); err != nil {
+				return 0, err
+			}
+			fs.lastDrop = dropped
+		}
+	}
+	if err := fs.writeLocked(buf); err != nil {
+		return 0, err
+	}
+	if fs.opts.Fsync == FsyncEveryWrite {
+		if err := fs.f.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+func (fs *fileSink) writeLocked(buf []byte) error {
+	n, err := fs.f.Write(buf)
+	fs.size += int64(n)
+	return err
+}
+
+func (fs *fileSink) rotateIfDueLocked() error {
+	due := fs.opts.MaxFileSize > 0 && fs.size >= fs.opts.MaxFileSize
+	if !due && fs.opts.MaxAge > 0 && time.Since(fs.openedAt) >= fs.opts.MaxAge {
+		due = true
+	}
+	if !due || fs.size == 0 {
+		return nil
+	}
+	return fs.rotateLocked()
+}
+
+func (fs *fileSink) rotateLocked() error {
+	if fs.opts.Fsync == FsyncOnRotate {
+		if err := fs.f.Sync(); err != nil {
+			fs.f.Close()
+			return err
+		}
+	}
+	if err := fs.f.Close(); err != nil {
+		return err
+	}
+	rotated := fs.path + "." + time.Now().UTC().Format("20060102T150405.000000000Z")
+	if err := os.Rename(fs.path, rotated); err != nil {
+		return err
+	}
+	if fs.opts.Fsync == FsyncOnRotate {
+		if dir, err := os.Open(filepath.Dir(fs.path)); err == nil {
+			dir.Sync()
+			dir.Close()
+		}
+	}
+	if fs.opts.Gzip {
+		if err := gzipAndRemove(rotated); err != nil {
+			return err
+		}
+	}
+	if err := fs.openCurrent(); err != nil {
+		return err
+	}
+	return fs.pruneLocked()
+}
+
+// gzipAndRemove compresses path into path+".gz" and removes path.
+func gzipAndRemove(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		out.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pruneLocked deletes the oldest rotated files until the total size
+// of the remaining ones is at most MaxTotalBytes.
+func (fs *fileSink) pruneLocked() error {
+	if fs.opts.MaxTotalBytes <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(fs.path + ".*")
+	if err != nil {
+		return err
+	}
+	// The timestamp suffix sorts lexically in chronological
+	// order, so the oldest rotated files come first.
+	sort.Strings(matches)
+	sizes := make([]int64, len(matches))
+	var total int64
+	for i, m := range matches {
+		if info, err := os.Stat(m); err == nil {
+			sizes[i] = info.Size()
+			total += info.Size()
+		}
+	}
+	for i := 0; i < len(matches) && total > fs.opts.MaxTotalBytes; i++ {
+		if err := os.Remove(matches[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+func (fs *fileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return nil
+	}
+	fs.closed = true
+	return fs.f.Close()
+}
@@ -0,0 +1,136 @@
+package nbtee
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// frameHeaderLen is the size, in bytes, of a FramedSink frame header:
+// a 4-byte length, an 8-byte sequence number, an 8-byte timestamp,
+// and a 1-byte flags field.
+const frameHeaderLen = 4 + 8 + 8 + 1
+
+// flagDroppedSinceLast is set in a frame's flags byte when the
+// source reported additional dropped bytes since the previous frame
+// was written.
+const flagDroppedSinceLast = 1 << 0
+
+// FramingOptions configures FramedSink.
+type FramingOptions struct {
+	// Dropped, if non-nil, is called before each frame is written
+	// to get a cumulative count of bytes dropped upstream of this
+	// sink, typically SinkCloser.Stats().BytesDropped for a sink
+	// added with a non-blocking Policy. If the count has
+	// increased since the previous frame, the new frame's
+	// "dropped-since-last" flag is set, so a FramedReader can tell
+	// it is missing data even though the sequence number is
+	// otherwise contiguous.
+	Dropped func() int64
+}
+
+type framedSink struct {
+	io.WriteCloser
+	opts FramingOptions
+
+	mu       sync.Mutex
+	seq      uint64
+	lastDrop int64
+}
+
+// FramedSink wraps w so that every Write is recorded as a
+// length-prefixed, timestamped frame: a 4-byte big-endian payload
+// length, an 8-byte big-endian sequence number, an 8-byte big-endian
+// unix-nanosecond timestamp, a 1-byte flags field, and finally the
+// payload itself.
+//
+// This lets a downstream consumer of an nbtee stream (log shipping,
+// tcpdump-style capture, replay tools) see where it missed writes due
+// to nbtee's backpressure. Writes dropped upstream never reach
+// FramedSink and so do not consume a sequence number; instead (via
+// opts.Dropped) the frame immediately following a drop carries the
+// "dropped-since-last" flag. The flag does not say how many writes
+// were missed. Use FramedReader to decode the frames this produces.
+func FramedSink(w io.WriteCloser, opts FramingOptions) io.WriteCloser {
+	return &framedSink{WriteCloser: w, opts: opts}
+}
+
+func (f *framedSink) Write(buf []byte) (int, error) {
+	// The mutex is held across the header and payload writes, not
+	// just while computing the header, so that concurrent callers
+	// cannot interleave one Write's header and payload with
+	// another's: FramedReader assumes frames arrive intact and in
+	// sequence. nbtee itself only ever calls a sink's Write from one
+	// goroutine at a time, but FramedSink is exported standalone and
+	// makes no such assumption of its caller.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seq := f.seq
+	f.seq++
+	var flags byte
+	if f.opts.Dropped != nil {
+		dropped := f.opts.Dropped()
+		if dropped > f.lastDrop {
+			flags |= flagDroppedSinceLast
+		}
+		f.lastDrop = dropped
+	}
+
+	header := make([]byte, frameHeaderLen)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(buf)))
+	binary.BigEndian.PutUint64(header[4:12], seq)
+	binary.BigEndian.PutUint64(header[12:20], uint64(time.Now().UnixNano()))
+	header[20] = flags
+	if _, err := f.WriteCloser.Write(header); err != nil {
+		return 0, err
+	}
+	if _, err := f.WriteCloser.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Frame is one record decoded by a FramedReader.
+type Frame struct {
+	Seq       uint64
+	Timestamp time.Time
+	Dropped   bool // data was dropped upstream since the previous frame
+	Payload   []byte
+}
+
+// FramedReader decodes the frames written by a FramedSink.
+type FramedReader struct {
+	r io.Reader
+}
+
+// NewFramedReader returns a FramedReader that reads frames from r.
+func NewFramedReader(r io.Reader) *FramedReader {
+	return &FramedReader{r: r}
+}
+
+// ReadFrame reads and decodes the next frame. It returns io.EOF if r
+// is exhausted exactly on a frame boundary, or io.ErrUnexpectedEOF if
+// r ends partway through a frame.
+func (fr *FramedReader) ReadFrame() (Frame, error) {
+	header := make([]byte, frameHeaderLen)
+	if _, err := io.ReadFull(fr.r, header); err != nil {
+		return Frame{}, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(fr.r, payload); err != nil {
+		if err == io.EOF {
+			// The header was read, so this is not a frame
+			// boundary.
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return Frame{
+		Seq:       binary.BigEndian.Uint64(header[4:12]),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[12:20]))),
+		Dropped:   header[20]&flagDroppedSinceLast != 0,
+		Payload:   payload,
+	}, nil
+}
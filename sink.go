@@ -1,19 +1,108 @@
 package nbtee
 
 import (
+	"errors"
 	"io"
+	"sync/atomic"
 )
 
+// SinkPolicy controls what happens to a sink's queued writes when it
+// cannot keep up with the writes arriving on the top-level Writer.
+type SinkPolicy int
+
+const (
+	// PolicyDropOldest is the default policy. When the sink's
+	// buffer is nearly full, the write that would fill it is
+	// replaced with a marker that tells the sink it has missed
+	// some data, and the sink keeps whatever it already had
+	// queued.
+	PolicyDropOldest SinkPolicy = iota
+
+	// PolicyDropNewest discards the incoming write instead of
+	// disturbing anything already queued for the sink.
+	PolicyDropNewest
+
+	// PolicyBlock makes the top-level Writer's Write (and Flush)
+	// block until this sink has room to accept the new data. Use
+	// this for a "primary" sink that must never miss anything.
+	//
+	// Because all sinks are currently served from the Writer's one
+	// internal goroutine, a slow PolicyBlock sink delays delivery
+	// to every other sink too, and stalls every other Write/Flush
+	// call, until it has room. Add at most one PolicyBlock sink,
+	// and expect it to set the pace for the whole Writer.
+	PolicyBlock
+
+	// PolicyDisconnectOnOverflow removes the sink as soon as its
+	// queued bytes would exceed maxBufferBytes (see AddWithPolicy),
+	// or (when BufsPerSink > 1) its buffer of queued writes is down
+	// to its last slot. The reason is available afterwards via Stats() and
+	// Close() on the SinkCloser returned by AddWithPolicy; the
+	// wrapped writer is closed by that Close() call, and Remove
+	// returns ErrNotFound for a sink that has been disconnected.
+	PolicyDisconnectOnOverflow
+)
+
+// ErrSinkOverflow is returned by Close() (and recorded in Stats()) for
+// a sink that was added with PolicyDisconnectOnOverflow and was
+// disconnected because its buffer overflowed, by bytes or by count.
+var ErrSinkOverflow = errors.New("sink disconnected: buffered bytes exceeded limit")
+
+// SinkStats reports a sink's cumulative activity. It is a snapshot:
+// the counters keep changing until the sink is removed.
+type SinkStats struct {
+	BytesWritten   int64 // bytes successfully written to the sink
+	BytesDropped   int64 // bytes discarded instead of being written
+	OverflowEvents int64 // number of times PolicyDisconnectOnOverflow fired
+}
+
+// SinkCloser is returned by Writer.Remove. Besides Close, it reports
+// the stats accumulated by the sink it was returned for.
+type SinkCloser interface {
+	io.Closer
+	Stats() SinkStats
+}
+
 type sink struct {
 	io.Writer
 	c        chan []byte
 	done     chan struct{}
 	flushers chan chan struct{}
 	err      error
+
+	policy         SinkPolicy
+	maxBufferBytes int64
+
+	queuedBytes  int64 // atomic
+	bytesWritten int64 // atomic
+	bytesDropped int64 // atomic
+	overflows    int64 // atomic
+
+	// overflowErr is set by Writer.run()'s goroutine, before it
+	// closes c, when this sink is disconnected by
+	// PolicyDisconnectOnOverflow. drain() picks it up as s.err
+	// once c is drained and closed.
+	overflowErr error
+}
+
+// Stats returns a snapshot of this sink's cumulative bytes written,
+// bytes dropped, and (for PolicyDisconnectOnOverflow sinks) overflow
+// events.
+func (s *sink) Stats() SinkStats {
+	return SinkStats{
+		BytesWritten:   atomic.LoadInt64(&s.bytesWritten),
+		BytesDropped:   atomic.LoadInt64(&s.bytesDropped),
+		OverflowEvents: atomic.LoadInt64(&s.overflows),
+	}
 }
 
 // Close the sink's writer (if it implements io.Closer) and return the
 // first error encountered by this sink's Write or Close.
+//
+// For a PolicyDisconnectOnOverflow sink that has already overflowed,
+// s.err is pre-set to ErrSinkOverflow, so a real error from the
+// wrapped writer's Close is discarded here rather than returned: the
+// overflow is reported, but a concurrent close failure is not.
 func (s *sink) Close() error {
 	<-s.done
 	if w, ok := s.Writer.(io.Closer); ok {
@@ -43,33 +132,95 @@ func (s *sink) Flush() {
 	}
 }
 
-// Write some bytes to the sink. If the sink is full, discard the
-// bytes. Return as soon as possible regardless of how slowly the sink
-// is draining.
-func (s *sink) Write(buf []byte) (int, error) {
+// full reports whether this sink's buffer (by count, or by
+// MaxBufferBytes if set) has no room left for a write of the given
+// size.
+func (s *sink) full(size int64) bool {
+	if cap(s.c) > 1 && len(s.c)+1 == cap(s.c) {
+		return true
+	}
+	if s.maxBufferBytes > 0 && atomic.LoadInt64(&s.queuedBytes)+size > s.maxBufferBytes {
+		return true
+	}
+	return false
+}
+
+// Write some bytes to the sink, applying its policy. It returns true
+// if the sink should now be removed from service (currently only
+// happens for PolicyDisconnectOnOverflow). Write must only be called
+// from the Writer's run() goroutine.
+func (s *sink) Write(buf []byte) (disconnect bool) {
 	select {
 	case <-s.done:
 		// sink encountered an error, no more data will be
 		// written
-		return 0, s.err
+		return false
 	default:
 	}
-	if cap(s.c) > 1 && len(s.c)+1 == cap(s.c) {
-		// Sending to the channel now might mean we can't send
-		// a nil buffer next time without blocking. So we send
-		// a nil buffer now. This notifies the drain()
-		// goroutine that it is missing incoming bufs by
-		// writing too slowly.
-		s.c <- nil
-	} else {
+	size := int64(len(buf))
+	switch s.policy {
+	case PolicyBlock:
+		select {
+		case <-s.done:
+			return false
+		case s.c <- buf:
+			atomic.AddInt64(&s.queuedBytes, size)
+		}
+		return false
+	case PolicyDisconnectOnOverflow:
+		if s.full(size) {
+			atomic.AddInt64(&s.overflows, 1)
+			s.overflowErr = ErrSinkOverflow
+			return true
+		}
+		select {
+		case s.c <- buf:
+			atomic.AddInt64(&s.queuedBytes, size)
+		default:
+			atomic.AddInt64(&s.bytesDropped, size)
+		}
+		return false
+	case PolicyDropNewest:
+		if s.full(size) {
+			atomic.AddInt64(&s.bytesDropped, size)
+			return false
+		}
 		select {
 		case s.c <- buf:
+			atomic.AddInt64(&s.queuedBytes, size)
 		default:
-			// channel not ready: this means either cap ==
-			// 0 or the last thing we sent was nil.
+			atomic.AddInt64(&s.bytesDropped, size)
+		}
+		return false
+	default: // PolicyDropOldest
+		if s.full(size) {
+			// Sending to the channel now might mean we
+			// can't send a nil buffer next time without
+			// blocking. So we send a nil buffer now. This
+			// notifies the drain() goroutine that it is
+			// missing incoming bufs by writing too
+			// slowly.
+			atomic.AddInt64(&s.bytesDropped, size)
+			select {
+			case s.c <- nil:
+			default:
+				// No room for the marker either (a
+				// byte limit was hit while the
+				// channel is full): just drop buf.
+			}
+		} else {
+			select {
+			case s.c <- buf:
+				atomic.AddInt64(&s.queuedBytes, size)
+			default:
+				// channel not ready: this means either
+				// cap <= 1 or the last thing we sent
+				// was nil.
+				atomic.AddInt64(&s.bytesDropped, size)
+			}
 		}
+		return false
 	}
-	return len(buf), nil
 }
 
 // Drain the sink by receiving buffers from c and writing them to the
@@ -78,8 +229,17 @@ func (s *sink) Write(buf []byte) (int, error) {
 func (s *sink) drain() {
 	for buf := range s.c {
 		if buf == nil {
+			atomic.StoreInt64(&s.queuedBytes, 0)
 			for len(s.c) > 0 {
-				<-s.c
+				if dropped := <-s.c; dropped != nil {
+					// These buffers were already queued
+					// behind the nil that triggered this
+					// flush, so they are discarded (not
+					// written) along with it; count their
+					// bytes as dropped so Stats() stays
+					// accurate.
+					atomic.AddInt64(&s.bytesDropped, int64(len(dropped)))
+				}
 			}
 		F:
 			for {
@@ -91,7 +251,9 @@ func (s *sink) drain() {
 				}
 			}
 		} else {
-			_, s.err = s.Writer.Write(buf)
+			n, err := s.Writer.Write(buf)
+			s.err = err
+			atomic.AddInt64(&s.queuedBytes, -int64(len(buf)))
 			if s.err != nil {
 				close(s.done)
 				s.Close()
@@ -99,8 +261,12 @@ func (s *sink) drain() {
 				}
 				return
 			}
+			atomic.AddInt64(&s.bytesWritten, int64(n))
 		}
 	}
+	if s.overflowErr != nil && s.err == nil {
+		s.err = s.overflowErr
+	}
 	close(s.done)
 	close(s.flushers)
 }
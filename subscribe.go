@@ -0,0 +1,166 @@
+package nbtee
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefaultSubscribeBufferBytes is used by Subscribe and
+// SubscribeWithOptions when SubscribeOptions.BufferBytes is zero.
+const DefaultSubscribeBufferBytes = 64 * 1024
+
+// SubscribeOptions configures Writer.SubscribeWithOptions.
+type SubscribeOptions struct {
+	// BufferBytes bounds how much unread data is kept for this
+	// subscriber. Once full, writing more data overwrites the
+	// oldest unread bytes. (This differs from PolicyDropOldest on an
+	// ordinary sink's write buffer, which keeps what is queued and
+	// discards the incoming write.) Zero selects
+	// DefaultSubscribeBufferBytes.
+	BufferBytes int
+}
+
+// Subscribe is equivalent to SubscribeWithOptions(SubscribeOptions{}).
+func (w *Writer) Subscribe() (io.ReadCloser, error) {
+	return w.SubscribeWithOptions(SubscribeOptions{})
+}
+
+// SubscribeWithOptions adds a new sink to w and returns its read end
+// as an io.ReadCloser, so a consumer can just call Read instead of
+// implementing io.Writer and calling Add itself.
+//
+// Internally, the write end is a bounded ring buffer rather than an
+// unbounded channel or a synchronous io.Pipe: if the caller's Read
+// falls behind, the oldest unread bytes are overwritten instead of
+// blocking w.Write. The sink feeding the ring buffer is added with
+// PolicyDropOldest like any other sink added by Add, but since its
+// Write never blocks, drops normally happen in the ring buffer, where
+// the oldest unread bytes are lost rather than the newest write.
+//
+// Closing the returned io.ReadCloser removes the underlying sink
+// from w; callers do not need to call Remove themselves.
+func (w *Writer) SubscribeWithOptions(opts SubscribeOptions) (io.ReadCloser, error) {
+	if opts.BufferBytes < 0 {
+		return nil, errors.New("nbtee: BufferBytes must not be negative")
+	}
+	bufferBytes := opts.BufferBytes
+	if bufferBytes == 0 {
+		bufferBytes = DefaultSubscribeBufferBytes
+	}
+	p := newRingPipe(bufferBytes)
+	w.Add(p)
+	return &subscription{w: w, p: p}, nil
+}
+
+// subscription is the io.ReadCloser returned by Subscribe. Its write
+// end (p) is Added to a Writer as an ordinary sink; subscription
+// itself only exposes Read and Close, so callers cannot accidentally
+// write to their own subscription.
+type subscription struct {
+	w *Writer
+	p *ringPipe
+}
+
+func (s *subscription) Read(buf []byte) (int, error) {
+	return s.p.Read(buf)
+}
+
+// Close removes and closes the underlying sink, then closes the ring
+// buffer for reading: subsequent Reads drain whatever was already
+// buffered and then return io.EOF. Close is idempotent.
+func (s *subscription) Close() error {
+	err := s.w.RemoveAndClose(s.p)
+	if err == ErrNotFound {
+		err = nil
+	}
+	s.p.closeForReading()
+	return err
+}
+
+// ringPipe is a bounded in-memory byte ring buffer used as the write
+// end of a subscription. Write never blocks and never drops an
+// error: once full, it overwrites the oldest unread bytes. Read
+// blocks until data is available or the pipe is closed.
+type ringPipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	start  int // index of oldest buffered byte
+	size   int // number of buffered bytes
+	closed bool
+}
+
+func newRingPipe(capacity int) *ringPipe {
+	p := &ringPipe{buf: make([]byte, capacity)}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// Write implements io.Writer. It reports len(buf) bytes written even
+// if some of them are immediately overwritten by the next Write,
+// consistent with this being a bounded buffer rather than a
+// best-effort one: the caller's data was accepted, just not
+// necessarily kept.
+func (p *ringPipe) Write(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(buf)
+	capacity := len(p.buf)
+	if capacity == 0 {
+		p.cond.Broadcast()
+		return n, nil
+	}
+	if n >= capacity {
+		copy(p.buf, buf[n-capacity:])
+		p.start = 0
+		p.size = capacity
+	} else {
+		if over := p.size + n - capacity; over > 0 {
+			p.start = (p.start + over) % capacity
+			p.size -= over
+		}
+		at := (p.start + p.size) % capacity
+		for _, b := range buf {
+			p.buf[at] = b
+			at = (at + 1) % capacity
+		}
+		p.size += n
+	}
+	p.cond.Broadcast()
+	return n, nil
+}
+
+// Read implements io.Reader, blocking until at least one byte is
+// buffered or the pipe is closed.
+func (p *ringPipe) Read(out []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.size == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.size == 0 {
+		return 0, io.EOF
+	}
+	n := len(out)
+	if n > p.size {
+		n = p.size
+	}
+	capacity := len(p.buf)
+	for i := 0; i < n; i++ {
+		out[i] = p.buf[(p.start+i)%capacity]
+	}
+	p.start = (p.start + n) % capacity
+	p.size -= n
+	return n, nil
+}
+
+// closeForReading marks the pipe closed and wakes any blocked Read,
+// which then drains whatever remains buffered before returning
+// io.EOF.
+func (p *ringPipe) closeForReading() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
@@ -18,6 +18,7 @@
 package nbtee
 
 import (
+	"context"
 	"errors"
 	"io"
 )
@@ -70,15 +71,39 @@ func (w *Writer) Start() *Writer {
 //
 // If the given writer has already been added (and not removed), Add
 // does nothing.
+//
+// Add is equivalent to AddWithPolicy(writer, PolicyDropOldest, 0).
 func (w *Writer) Add(writer io.Writer) {
+	w.AddWithPolicy(writer, PolicyDropOldest, 0)
+}
+
+// AddWithPolicy adds a writer like Add, but lets the caller choose
+// the sink's backpressure policy and (if non-zero) a limit on the
+// number of bytes that may be queued for this sink at once, in
+// addition to the BufsPerSink limit on the number of queued writes.
+// A PolicyBlock sink waits only for the BufsPerSink limit; its queued
+// bytes are not bounded by maxBufferBytes.
+//
+// The returned SinkCloser reports the sink's stats even after a
+// PolicyDisconnectOnOverflow sink has disconnected itself (at which
+// point a subsequent Remove would return ErrNotFound), and its
+// Close() method waits for and returns the sink's final error,
+// whether that came from the wrapped io.Writer's Write or (for
+// PolicyDisconnectOnOverflow) from the overflow itself. Once a sink
+// has overflowed, a subsequent error from the wrapped io.Writer's own
+// Close is not reported; ErrSinkOverflow takes priority.
+func (w *Writer) AddWithPolicy(writer io.Writer, policy SinkPolicy, maxBufferBytes int64) SinkCloser {
 	s := &sink{
-		Writer:   writer,
-		c:        make(chan []byte, w.BufsPerSink),
-		done:     make(chan struct{}),
-		flushers: make(chan chan struct{}, 1),
+		Writer:         writer,
+		c:              make(chan []byte, w.BufsPerSink),
+		done:           make(chan struct{}),
+		flushers:       make(chan chan struct{}, 1),
+		policy:         policy,
+		maxBufferBytes: maxBufferBytes,
 	}
 	go s.drain()
 	w.cmd <- cmdAdd(s)
+	return s
 }
 
 // RemoveAndClose removes a writer, waits for it to drain any buffered
@@ -96,14 +121,18 @@ func (w *Writer) RemoveAndClose(writer io.Writer) error {
 }
 
 // Remove removes a writer. Does not wait for buffered data to drain
-// before returning. Returns an io.Closer whose Close() method will
+// before returning. Returns a SinkCloser whose Close() method will
 // (if applicable) wait for all buffered data to be written, close the
 // original writer, and return any error encountered while writing or
-// closing.
+// closing. (A PolicyDisconnectOnOverflow sink that has overflowed is
+// already removed, so Remove returns ErrNotFound for it; its overflow
+// is reported by the SinkCloser returned by AddWithPolicy.) Its
+// Stats() method reports the sink's cumulative bytes written,
+// bytes dropped, and overflow events.
 //
 // Returns ErrNotFound if the writer was not added, or was already
 // removed.
-func (w *Writer) Remove(writer io.Writer) (io.Closer, error) {
+func (w *Writer) Remove(writer io.Writer) (SinkCloser, error) {
 	done := make(chan *sink)
 	w.cmd <- cmdRemove{Writer: writer, done: done}
 	sink, ok := <-done
@@ -122,6 +151,24 @@ func (w *Writer) Write(buf []byte) (int, error) {
 	return len(buf), nil
 }
 
+// WriteContext is like Write, but returns early with ctx.Err() if ctx
+// is done before buf can be handed off to run(). This is useful if
+// run() might be stuck, e.g. because a sink added with PolicyBlock is
+// slow to drain. Once the hand-off succeeds, the write proceeds
+// exactly as with Write: a ctx that is cancelled afterwards has no
+// effect on the data, which has already been queued for every sink.
+func (w *Writer) WriteContext(ctx context.Context, buf []byte) (int, error) {
+	bufcopy := make([]byte, len(buf))
+	copy(bufcopy, buf)
+	select {
+	case w.cmd <- cmdWrite(bufcopy):
+		return len(buf), nil
+	case <-ctx.Done():
+		// bufcopy is dropped, not delivered to run().
+		return 0, ctx.Err()
+	}
+}
+
 // Close frees all resources. However, it does not wait for sinks to
 // finish draining their buffers, or close them.
 //
@@ -146,6 +193,27 @@ func (w *Writer) Flush() {
 	<-done
 }
 
+// FlushContext is like Flush, but returns early with ctx.Err() if ctx
+// is done before the flush command can be handed off to run(), or
+// before run() replies that all current sinks have drained. In the
+// latter case, the reply (sent by closing the done channel once
+// run() catches up) is simply left unread: closing a channel never
+// blocks, so run() does not leak or get stuck waiting for us.
+func (w *Writer) FlushContext(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case w.cmd <- cmdFlush(done):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (w *Writer) run() {
 	sinks := map[interface{}]*sink{}
 	for cmd := range w.cmd {
@@ -177,8 +245,16 @@ func (w *Writer) run() {
 			}
 			close(cmd)
 		case cmdWrite:
-			for _, s := range sinks {
-				s.Write(cmd)
+			for k, s := range sinks {
+				if s.Write(cmd) {
+					// PolicyDisconnectOnOverflow:
+					// the sink recorded its
+					// overflow error and must now
+					// be removed and closed, same
+					// as an explicit Remove.
+					close(s.c)
+					delete(sinks, k)
+				}
 			}
 		}
 	}